@@ -0,0 +1,213 @@
+/*
+ * Tencent is pleased to support the open source community by making 蓝鲸 available.
+ * Copyright (C) 2017-2018 THL A29 Limited, a Tencent company. All rights reserved.
+ * Licensed under the MIT License (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ * http://opensource.org/licenses/MIT
+ * Unless required by applicable law or agreed to in writing, software distributed under
+ * the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+ * either express or implied. See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registerdiscover
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"configcenter/src/common/blog"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// consulTTL is the check TTL a registered service is expected to report within, it is
+// refreshed well before it expires so consul does not mark the service as critical.
+const consulTTL = 10 * time.Second
+
+// ConsulRegDiscv do register and discover by consul, it implements the same
+// RegDiscvServer contract that ZkRegDiscv does.
+type ConsulRegDiscv struct {
+	cli     *consulapi.Client
+	stopCh  chan struct{}
+	stopped bool
+	sync.Mutex
+	// registeredPathsMap is all registered service ids, concurrent secure
+	registeredPathsMap sync.Map
+}
+
+// NewConsulRegDiscv create a object of ConsulRegDiscv
+func NewConsulRegDiscv(endpoints []string) (*ConsulRegDiscv, error) {
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("consul endpoints can not be empty")
+	}
+
+	cfg := consulapi.DefaultConfig()
+	cfg.Address = endpoints[0]
+	cli, err := consulapi.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConsulRegDiscv{
+		cli:    cli,
+		stopCh: make(chan struct{}),
+	}, nil
+}
+
+// Register registers path as a consul service id with data as its tag payload, without
+// starting the TTL heartbeat that keeps it passing.
+func (c *ConsulRegDiscv) Register(path string, data []byte) error {
+	if err := c.registerService(path, data); err != nil {
+		return err
+	}
+	c.registeredPathsMap.Store(path, struct{}{})
+	return nil
+}
+
+// RegisterAndWatch registers path as a consul service and keeps passing its TTL check
+// for as long as the client lives, re-registering it whenever the TTL update fails.
+func (c *ConsulRegDiscv) RegisterAndWatch(path string, data []byte) error {
+	blog.Infof("register server and watch it. path(%s), data(%s)", path, string(data))
+
+	go func() {
+		ticker := time.NewTicker(consulTTL / 2)
+		defer ticker.Stop()
+
+		for {
+			if err := c.registerService(path, data); err != nil {
+				blog.Errorf("fail to register server node(%s) to consul, err: %v", path, err)
+				time.Sleep(time.Second)
+				continue
+			}
+			c.registeredPathsMap.Store(path, struct{}{})
+			break
+		}
+
+		for {
+			select {
+			case <-c.stopCh:
+				return
+			case <-ticker.C:
+				checkID := "service:" + path
+				if err := c.cli.Agent().UpdateTTL(checkID, "", consulapi.HealthPassing); err != nil {
+					blog.Errorf("fail to refresh consul ttl check(%s), will register again, err: %v", checkID, err)
+					c.registeredPathsMap.Delete(path)
+					if regErr := c.registerService(path, data); regErr != nil {
+						blog.Errorf("fail to re-register server node(%s) to consul, err: %v", path, regErr)
+						continue
+					}
+					c.registeredPathsMap.Store(path, struct{}{})
+				}
+			}
+		}
+	}()
+
+	blog.Infof("finish register server node(%s) and watch it", path)
+	return nil
+}
+
+func (c *ConsulRegDiscv) registerService(path string, data []byte) error {
+	reg := &consulapi.AgentServiceRegistration{
+		ID:   path,
+		Name: path,
+		Tags: []string{string(data)},
+		Check: &consulapi.AgentServiceCheck{
+			TTL:                            consulTTL.String(),
+			DeregisterCriticalServiceAfter: (consulTTL * 6).String(),
+		},
+	}
+	return c.cli.Agent().ServiceRegister(reg)
+}
+
+// Discover watches, through consul's blocking queries, the healthy instances of the
+// service whose name is exactly path, and reports the newest server list whenever it
+// differs from the last one reported. services registered under any other name,
+// including longer paths that start with path, are not reported.
+func (c *ConsulRegDiscv) Discover(path string) (<-chan *DiscoverEvent, error) {
+	env := make(chan *DiscoverEvent, 1)
+
+	go func() {
+		var oldServer map[string]bool
+		var lastIndex uint64
+
+		for {
+			select {
+			case <-c.stopCh:
+				return
+			default:
+			}
+
+			services, meta, err := c.cli.Health().Service(path, "", true, &consulapi.QueryOptions{
+				WaitIndex: lastIndex,
+				WaitTime:  30 * time.Second,
+			})
+			if err != nil {
+				// retry internally instead of pushing a DiscoverEvent built from
+				// this error: an empty Server list would make diffServer report
+				// every server under path as gone on a momentary query failure.
+				blog.Errorf("fail to discover consul service(%s), err: %v, will retry after 1s", path, err)
+				time.Sleep(time.Second)
+				continue
+			}
+
+			lastIndex = meta.LastIndex
+			event := &DiscoverEvent{Key: path}
+			for _, svc := range services {
+				event.Nodes = append(event.Nodes, svc.Service.ID)
+				if len(svc.Service.Tags) > 0 {
+					event.Server = append(event.Server, svc.Service.Tags[0])
+				}
+			}
+
+			newServer, changed := diffServer(oldServer, event)
+			oldServer = newServer
+			if changed {
+				env <- event
+			}
+		}
+	}()
+
+	return env, nil
+}
+
+// Ping to ping server
+func (c *ConsulRegDiscv) Ping() error {
+	_, err := c.cli.Agent().Self()
+	return err
+}
+
+// Deregister removes the service previously registered at path.
+func (c *ConsulRegDiscv) Deregister(path string) error {
+	if err := c.cli.Agent().ServiceDeregister(path); err != nil {
+		return err
+	}
+	c.registeredPathsMap.Delete(path)
+	return nil
+}
+
+// ClearRegisterPath removes every service this instance has registered.
+func (c *ConsulRegDiscv) ClearRegisterPath() error {
+	var err error
+	c.registeredPathsMap.Range(func(k, v interface{}) bool {
+		if delErr := c.cli.Agent().ServiceDeregister(k.(string)); delErr != nil {
+			err = delErr
+			return false
+		}
+		c.registeredPathsMap.Delete(k)
+		return true
+	})
+	return err
+}
+
+// Cancel to stop server register and discover
+func (c *ConsulRegDiscv) Cancel() {
+	c.Lock()
+	defer c.Unlock()
+	if c.stopped {
+		return
+	}
+	c.stopped = true
+	close(c.stopCh)
+}
@@ -0,0 +1,230 @@
+/*
+ * Tencent is pleased to support the open source community by making 蓝鲸 available.
+ * Copyright (C) 2017-2018 THL A29 Limited, a Tencent company. All rights reserved.
+ * Licensed under the MIT License (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ * http://opensource.org/licenses/MIT
+ * Unless required by applicable law or agreed to in writing, software distributed under
+ * the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+ * either express or implied. See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registerdiscover
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"configcenter/src/common/blog"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// etcdLeaseTTL is the lease duration a registered node is kept alive under, it is
+// refreshed by KeepAlive well before it can expire.
+const etcdLeaseTTL = 10
+
+// EtcdRegDiscv do register and discover by etcd v3, it implements the same
+// RegDiscvServer contract that ZkRegDiscv does.
+type EtcdRegDiscv struct {
+	cli     *clientv3.Client
+	cancel  context.CancelFunc
+	rootCxt context.Context
+	sync.Mutex
+	// registeredPathsMap is all registered paths and their lease id, concurrent secure
+	registeredPathsMap sync.Map
+}
+
+// NewEtcdRegDiscv create a object of EtcdRegDiscv
+func NewEtcdRegDiscv(endpoints []string) (*EtcdRegDiscv, error) {
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &EtcdRegDiscv{
+		cli:     cli,
+		cancel:  cancel,
+		rootCxt: ctx,
+	}, nil
+}
+
+// Register creates path with data under a lease, without keeping it alive afterwards.
+func (e *EtcdRegDiscv) Register(path string, data []byte) error {
+	leaseID, err := e.grantAndPut(path, data)
+	if err != nil {
+		return err
+	}
+	e.registeredPathsMap.Store(path, leaseID)
+	return nil
+}
+
+// RegisterAndWatch creates path with data and keeps renewing its lease for as long as
+// the client lives, re-creating the node whenever the keep-alive channel is closed.
+func (e *EtcdRegDiscv) RegisterAndWatch(path string, data []byte) error {
+	blog.Infof("register server and watch it. path(%s), data(%s)", path, string(data))
+
+	go func() {
+		for {
+			select {
+			case <-e.rootCxt.Done():
+				return
+			default:
+			}
+
+			leaseID, err := e.grantAndPut(path, data)
+			if err != nil {
+				blog.Errorf("fail to register server node(%s) to etcd, err: %v", path, err)
+				time.Sleep(time.Second)
+				continue
+			}
+			e.registeredPathsMap.Store(path, leaseID)
+
+			keepAlive, err := e.cli.KeepAlive(e.rootCxt, leaseID)
+			if err != nil {
+				blog.Errorf("fail to keep lease alive for node(%s), err: %v", path, err)
+				time.Sleep(time.Second)
+				continue
+			}
+
+			// drain the keep-alive channel until etcd closes it, which means the
+			// lease expired or the connection was lost, at which point we loop
+			// around and register the node again.
+			for range keepAlive {
+			}
+			blog.Errorf("lease for node(%s) is no longer kept alive, will register again", path)
+			e.registeredPathsMap.Delete(path)
+		}
+	}()
+
+	blog.Infof("finish register server node(%s) and watch it", path)
+	return nil
+}
+
+func (e *EtcdRegDiscv) grantAndPut(path string, data []byte) (clientv3.LeaseID, error) {
+	lease, err := e.cli.Grant(e.rootCxt, etcdLeaseTTL)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := e.cli.Put(e.rootCxt, path, string(data), clientv3.WithLease(lease.ID)); err != nil {
+		return 0, err
+	}
+
+	return lease.ID, nil
+}
+
+// Discover watches the children of path and reports the newest server list on every change.
+func (e *EtcdRegDiscv) Discover(path string) (<-chan *DiscoverEvent, error) {
+	env := make(chan *DiscoverEvent, 1)
+
+	go func() {
+		var oldServer map[string]bool
+		watchCh := e.cli.Watch(e.rootCxt, path, clientv3.WithPrefix())
+
+		// push the initial state before waiting for the first watch event.
+		event := e.getServerInfoByPath(path)
+		newServer, changed := diffServer(oldServer, event)
+		oldServer = newServer
+		if changed {
+			env <- event
+		}
+
+		for {
+			select {
+			case <-e.rootCxt.Done():
+				return
+			case resp, ok := <-watchCh:
+				if !ok {
+					blog.Errorf("etcd watch channel for path(%s) is closed, stop discovering", path)
+					return
+				}
+				if resp.Err() != nil {
+					blog.Errorf("etcd watch for path(%s) error: %v", path, resp.Err())
+					continue
+				}
+
+				event := e.getServerInfoByPath(path)
+				newServer, changed := diffServer(oldServer, event)
+				oldServer = newServer
+				if changed {
+					env <- event
+				}
+			}
+		}
+	}()
+
+	return env, nil
+}
+
+// getServerInfoByPath retries internally on a transient Get error instead of returning
+// a DiscoverEvent with an empty server list, the same way ZkRegDiscv's own
+// getServerInfoByPath does, so a momentary network blip never looks like every server
+// under path disappeared to diffServer.
+func (e *EtcdRegDiscv) getServerInfoByPath(path string) *DiscoverEvent {
+	for {
+		discvEnv := &DiscoverEvent{Key: path}
+
+		resp, err := e.cli.Get(e.rootCxt, path, clientv3.WithPrefix())
+		if err != nil {
+			blog.Errorf("fail to get server info from etcd by path(%s), err: %v, will retry after 1s",
+				path, err)
+			select {
+			case <-e.rootCxt.Done():
+				discvEnv.Err = err
+				return discvEnv
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		for _, kv := range resp.Kvs {
+			discvEnv.Nodes = append(discvEnv.Nodes, string(kv.Key))
+			discvEnv.Server = append(discvEnv.Server, string(kv.Value))
+		}
+
+		return discvEnv
+	}
+}
+
+// Ping to ping server
+func (e *EtcdRegDiscv) Ping() error {
+	ctx, cancel := context.WithTimeout(e.rootCxt, 3*time.Second)
+	defer cancel()
+	_, err := e.cli.Get(ctx, "health-ping")
+	return err
+}
+
+// Deregister removes the node previously registered at path.
+func (e *EtcdRegDiscv) Deregister(path string) error {
+	if _, err := e.cli.Delete(e.rootCxt, path); err != nil {
+		return err
+	}
+	e.registeredPathsMap.Delete(path)
+	return nil
+}
+
+// ClearRegisterPath removes every path this instance has registered.
+func (e *EtcdRegDiscv) ClearRegisterPath() error {
+	var err error
+	e.registeredPathsMap.Range(func(k, v interface{}) bool {
+		if _, delErr := e.cli.Delete(e.rootCxt, k.(string)); delErr != nil {
+			err = delErr
+			return false
+		}
+		e.registeredPathsMap.Delete(k)
+		return true
+	})
+	return err
+}
+
+// Cancel to stop server register and discover
+func (e *EtcdRegDiscv) Cancel() {
+	e.cancel()
+}
@@ -0,0 +1,126 @@
+/*
+ * Tencent is pleased to support the open source community by making 蓝鲸 available.
+ * Copyright (C) 2017-2018 THL A29 Limited, a Tencent company. All rights reserved.
+ * Licensed under the MIT License (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ * http://opensource.org/licenses/MIT
+ * Unless required by applicable law or agreed to in writing, software distributed under
+ * the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+ * either express or implied. See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registerdiscover
+
+import "fmt"
+
+// Backend is the kind of storage that a RegDiscvServer is backed by.
+type Backend string
+
+const (
+	// BackendZookeeper registers and discovers services through zookeeper. it is
+	// identified here so RegDiscvServer implementations can be compared/logged by
+	// Backend uniformly, but it is a known, accepted limitation that it can not be
+	// selected through NewRegDiscvServer/Config today: see NewRegDiscvServer's comment.
+	BackendZookeeper Backend = "zookeeper"
+	// BackendEtcd registers and discovers services through etcd v3.
+	BackendEtcd Backend = "etcd"
+	// BackendConsul registers and discovers services through consul.
+	BackendConsul Backend = "consul"
+)
+
+// RegDiscvServer is the common contract every register/discover backend has to implement,
+// once one is constructed. it lets call sites that do Register/Discover/Ping stay
+// identical across backends; swapping etcd for consul is a configuration-only change via
+// NewRegDiscvServer. zookeeper implements the same contract but, for now, is still
+// constructed directly through NewZkRegDiscv, see its comment for why.
+type RegDiscvServer interface {
+	// Register creates the node at path with data, without watching or re-registering it.
+	Register(path string, data []byte) error
+	// RegisterAndWatch creates the node at path with data and keeps it registered for as
+	// long as the backend's underlying connection lives, re-registering it whenever the
+	// backend reports it has disappeared.
+	RegisterAndWatch(path string, data []byte) error
+	// Discover watches the children of path and reports the newest server list on every change.
+	Discover(path string) (<-chan *DiscoverEvent, error)
+	// Deregister removes the node previously registered at path.
+	Deregister(path string) error
+	// ClearRegisterPath removes every path this instance has registered.
+	ClearRegisterPath() error
+	// Ping checks that the backend connection is healthy.
+	Ping() error
+}
+
+// DiscoverEvent is the newest server list discovered under a watched path.
+type DiscoverEvent struct {
+	// Err is set when the discover backend failed to retrieve the newest server list.
+	Err error
+	// Key is the path that's being watched.
+	Key string
+	// Nodes are the entries found under Key: the raw child node names for zookeeper,
+	// the full keys for etcd and the service IDs for consul.
+	Nodes []string
+	// Server are the data associated with each entry under Key. for etcd this is in
+	// lockstep with Nodes, both are appended from the same per-entry loop; for consul
+	// too, except that a service with no tag is added to Nodes but not to Server; for
+	// zookeeper it is ordered by sortNode instead, which does not necessarily match
+	// Nodes' own (unsorted) order.
+	Server []string
+}
+
+// diffServer tells whether the server list carried by event is different from the
+// previously known oldServer set, and returns the new set to be remembered for the
+// next round. the etcd and consul discover loops, and the polling loop in
+// ZkRegDiscv.Discover, use it to push a DiscoverEvent onto the channel only when
+// something actually changed, instead of on every poll/watch tick. ZkRegDiscv's
+// loopDiscover does not use it: it pushes the server list on every children-watch
+// trigger, whether or not the list changed.
+func diffServer(oldServer map[string]bool, event *DiscoverEvent) (newServer map[string]bool, changed bool) {
+	newServer = make(map[string]bool)
+	if len(event.Server) != len(oldServer) {
+		changed = true
+	}
+	for _, server := range event.Server {
+		if !changed && !oldServer[server] {
+			changed = true
+		}
+		newServer[server] = true
+	}
+	return newServer, changed
+}
+
+// Config describes which backend a RegDiscvServer should be built against, it is meant
+// to be filled in from the process's own configuration file so that switching between
+// etcd and consul never requires a code change at the call site. Note: this does not
+// (yet) extend to zookeeper, see NewRegDiscvServer.
+type Config struct {
+	// Backend selects which implementation NewRegDiscvServer returns.
+	Backend Backend
+	// Endpoints are the backend's connection addresses, e.g. "127.0.0.1:2379" for etcd
+	// or "127.0.0.1:8500" for consul.
+	Endpoints []string
+}
+
+// NewRegDiscvServer builds the RegDiscvServer implementation selected by cfg.Backend,
+// for the backends that can be built from a bare list of addresses.
+//
+// Known, accepted limitation: BackendZookeeper is not buildable from Config and never
+// returned here. ZkRegDiscv needs an already-connected, already-authenticated
+// *zk.ZkClient (session/auth state a []string of Endpoints can't express), so existing
+// zookeeper call sites keep constructing it directly through NewZkRegDiscv instead of
+// switching to this constructor. Making zookeeper selectable the same way would require
+// Config to grow a zk-specific session field (or accept a pre-built *zk.ZkClient
+// alongside Backend/Endpoints), which is out of scope here.
+func NewRegDiscvServer(cfg Config) (RegDiscvServer, error) {
+	switch cfg.Backend {
+	case BackendEtcd:
+		return NewEtcdRegDiscv(cfg.Endpoints)
+	case BackendConsul:
+		return NewConsulRegDiscv(cfg.Endpoints)
+	case BackendZookeeper:
+		return nil, fmt.Errorf("zookeeper backend can not be built from Config, " +
+			"build a *zk.ZkClient and call NewZkRegDiscv directly instead")
+	default:
+		return nil, fmt.Errorf("unsupported register/discover backend: %s", cfg.Backend)
+	}
+}
@@ -0,0 +1,41 @@
+/*
+ * Tencent is pleased to support the open source community by making 蓝鲸 available.
+ * Copyright (C) 2017-2018 THL A29 Limited, a Tencent company. All rights reserved.
+ * Licensed under the MIT License (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ * http://opensource.org/licenses/MIT
+ * Unless required by applicable law or agreed to in writing, software distributed under
+ * the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+ * either express or implied. See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registerdiscover
+
+import (
+	"time"
+
+	gozk "github.com/samuel/go-zookeeper/zk"
+)
+
+// ZkClient is the subset of *zkclient.ZkClient that ZkRegDiscv actually uses. it
+// exists so ZkRegDiscv can be built against a fake in tests, instead of requiring a
+// live zookeeper for every test that touches register/discover logic.
+type ZkClient interface {
+	CreateEphAndSeqEx(path string, data []byte) (string, error)
+	CreateEphAndSeqExWithACL(path string, data []byte, acl []gozk.ACL) (string, error)
+	ExistW(path string) (bool, *gozk.Stat, <-chan gozk.Event, error)
+	Exist(path string) (bool, error)
+	GetChildren(path string) ([]string, error)
+	Get(path string) (string, error)
+	Del(path string, version int32) error
+	Ping() error
+	ConnectEx(sessionTimeOut time.Duration) error
+	IsConnectionError(err error) bool
+	WatchChildren(path string) ([]string, <-chan gozk.Event, error)
+	GetACL(path string) ([]gozk.ACL, *gozk.Stat, error)
+	SetACL(path string, acl []gozk.ACL, version int32) (*gozk.Stat, error)
+	// Conn exposes the raw zk connection for the few calls (Ping liveness check,
+	// AddAuth) that have no higher-level wrapper on ZkClient itself.
+	Conn() *gozk.Conn
+}
@@ -14,9 +14,11 @@ package registerdiscover
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -29,13 +31,48 @@ import (
 
 // ZkRegDiscv do register and discover by zookeeper
 type ZkRegDiscv struct {
-	zkcli          *zkclient.ZkClient
+	zkcli          ZkClient
 	cancel         context.CancelFunc
 	rootCxt        context.Context
 	sessionTimeOut time.Duration
 	sync.Mutex
 	// registeredPathsMap is all registered paths and it's concurrent secure
 	registeredPathsMap sync.Map
+	// registrationID is a GUID baked into every node this instance creates, right
+	// before zookeeper's sequential suffix. it's what makes CreateEphAndSeqEx
+	// "protected": if a create looks like it failed but actually succeeded on the
+	// server (e.g. the connection dropped right after), a retry can recognize and
+	// adopt the node it already owns instead of creating a duplicate.
+	registrationID string
+
+	credMu sync.RWMutex
+	// auth is the scheme+credential this instance authenticates its zk session
+	// with, nil means the session stays anonymous like before.
+	auth *AuthConfig
+	// acl is applied to every node this instance creates when set, instead of
+	// zookeeper's default world-open ACL.
+	acl []gozk.ACL
+}
+
+// AuthConfig is the scheme+credential pair a ZkRegDiscv authenticates its zookeeper
+// session with, e.g. AuthConfig{Scheme: "digest", Credential: []byte("user:pass")}.
+type AuthConfig struct {
+	Scheme     string
+	Credential []byte
+}
+
+// SetAuth configures the credential this instance authenticates its zookeeper session
+// with, and applies it to the current session immediately if one is already connected,
+// so a caller that sets it after construction does not have to wait for a reconnect
+// before it takes effect. it is re-applied automatically on every future reconnect too.
+func (zkRD *ZkRegDiscv) SetAuth(auth AuthConfig) {
+	zkRD.credMu.Lock()
+	zkRD.auth = &auth
+	zkRD.credMu.Unlock()
+
+	if err := zkRD.applyAuth(); err != nil {
+		blog.Errorf("fail to apply zk auth immediately, will retry on next reconnect, err:%s", err.Error())
+	}
 }
 
 // NewZkRegDiscv create a object of ZkRegDiscv
@@ -46,9 +83,112 @@ func NewZkRegDiscv(client *zk.ZkClient) *ZkRegDiscv {
 		sessionTimeOut: client.SessionTimeOut(),
 		cancel:         ctxCancel,
 		rootCxt:        ctx,
+		registrationID: newRegistrationID(),
 	}
 }
 
+// RegistrationID returns the GUID this instance bakes into every node it registers,
+// callers normally don't need this, it's mainly useful for diagnosing ghost nodes.
+func (zkRD *ZkRegDiscv) RegistrationID() string {
+	return zkRD.registrationID
+}
+
+// newRegistrationID generates a client-side GUID prefix in the same shape go-kit's
+// sd/zk client uses for its protected ephemeral-sequential nodes, e.g. "_c_<uuid>-".
+func newRegistrationID() string {
+	b := make([]byte, 16)
+	// crypto/rand.Read on an adequately sized buffer never fails in practice, so its
+	// error is ignored. if it did fail, b would stay all zeroes, every instance hit by
+	// that would share the same registrationID, and findOwnNode could then adopt a
+	// node that another of those instances created.
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("_c_%x-", b)
+}
+
+// var _ RegDiscvServer = (*ZkRegDiscv)(nil) asserts ZkRegDiscv implements the common
+// register/discover contract alongside EtcdRegDiscv and ConsulRegDiscv.
+var _ RegDiscvServer = (*ZkRegDiscv)(nil)
+
+// Register creates the ephemeral sequential node at path with data, without watching
+// or re-registering it afterwards.
+func (zkRD *ZkRegDiscv) Register(path string, data []byte) error {
+	registerPath, err := zkRD.createProtectedEphAndSeq(path, data)
+	if err != nil {
+		return err
+	}
+	zkRD.registeredPathsMap.Store(registerPath, struct{}{})
+	return nil
+}
+
+// createProtectedEphAndSeq creates an ephemeral sequential node the same way
+// CreateEphAndSeqEx does, except the node name it creates has this instance's
+// registrationID baked in right before the sequential suffix. if the create call
+// itself returns an error, we can not tell whether it actually failed on the server
+// or only the reply got lost (e.g. the session reconnected mid-call) so before
+// retrying, we list the children of path's parent, where the node is created, and
+// look for one carrying our registrationID:
+// if it's there, the earlier create actually succeeded and we adopt it rather than
+// creating a second, ghost node.
+func (zkRD *ZkRegDiscv) createProtectedEphAndSeq(path string, data []byte) (string, error) {
+	protectedPath := path + zkRD.registrationID
+
+	zkRD.credMu.RLock()
+	acl := zkRD.acl
+	zkRD.credMu.RUnlock()
+
+	var registerPath string
+	var err error
+	if len(acl) > 0 {
+		registerPath, err = zkRD.zkcli.CreateEphAndSeqExWithACL(protectedPath, data, acl)
+	} else {
+		registerPath, err = zkRD.zkcli.CreateEphAndSeqEx(protectedPath, data)
+	}
+	if err == nil {
+		return registerPath, nil
+	}
+
+	if adopted, ok := zkRD.findOwnNode(path); ok {
+		blog.Infof("create node(%s) looked like it failed(%s) but the node already exists, adopt %s",
+			protectedPath, err.Error(), adopted)
+		return adopted, nil
+	}
+
+	return "", err
+}
+
+// findOwnNode looks among the children of path's parent (everything in path up to its
+// last "/"), which is where a node created from path+registrationID lives, for a node
+// whose name contains this instance's registrationID, and returns its full path if found.
+func (zkRD *ZkRegDiscv) findOwnNode(path string) (string, bool) {
+	dir := path[:strings.LastIndex(path, "/")+1]
+	parent := strings.TrimSuffix(dir, "/")
+	if parent == "" {
+		parent = "/"
+	}
+
+	children, err := zkRD.zkcli.GetChildren(parent)
+	if err != nil {
+		return "", false
+	}
+
+	for _, child := range children {
+		if strings.Contains(child, zkRD.registrationID) {
+			return dir + child, true
+		}
+	}
+
+	return "", false
+}
+
+// Deregister removes the node previously registered at path.
+func (zkRD *ZkRegDiscv) Deregister(path string) error {
+	if err := zkRD.zkcli.Del(path, -1); err != nil {
+		return err
+	}
+	zkRD.registeredPathsMap.Delete(path)
+	return nil
+}
+
 // pathMgr a path manager to operator on register path and it's concurrent secure
 type pathMgr struct {
 	sync.RWMutex
@@ -76,6 +216,27 @@ func (m *pathMgr) setRegisterPath(path string) {
 	m.registerPath = path
 }
 
+// RegisterAndWatchWithACL behaves exactly like RegisterAndWatch, except every node it
+// creates for path, including on re-register after a watch/connection error, carries
+// acl instead of zookeeper's default world-open ACL. combine it with SetAuth so this
+// instance's own session can still read/write what it registers.
+func (zkRD *ZkRegDiscv) RegisterAndWatchWithACL(path string, data []byte, acl []gozk.ACL) error {
+	zkRD.credMu.Lock()
+	zkRD.acl = acl
+	zkRD.credMu.Unlock()
+
+	// the session may already be connected and authenticated before this call (e.g.
+	// SetAuth was used earlier), but make sure of it here too: a caller that never
+	// got a chance to call SetAuth before the session connected would otherwise only
+	// authenticate on the next reconnect, and the very first create against an
+	// ACL-protected path would fail with a permission error in the meantime.
+	if err := zkRD.applyAuth(); err != nil {
+		blog.Errorf("fail to apply zk auth before registering with acl, err:%s", err.Error())
+	}
+
+	return zkRD.RegisterAndWatch(path, data)
+}
+
 // RegisterAndWatch create ephemeral node for the service and watch it. if it exit, register again
 func (zkRD *ZkRegDiscv) RegisterAndWatch(path string, data []byte) error {
 	blog.Infof("register server and watch it. path(%s), data(%s)", path, string(data))
@@ -89,7 +250,7 @@ func (zkRD *ZkRegDiscv) RegisterAndWatch(path string, data []byte) error {
 			var err error
 
 			if pathMgr.getRegisterPath() == "" {
-				registerPath, err := zkRD.zkcli.CreateEphAndSeqEx(path, data)
+				registerPath, err := zkRD.createProtectedEphAndSeq(path, data)
 				if err != nil {
 					blog.Errorf("fail to register server node(%s). CreateEphAndSeqEx err:%s", path, err.Error())
 					if zkRD.zkcli.IsConnectionError(err) {
@@ -187,7 +348,7 @@ func (zkRD *ZkRegDiscv) loopRegisterNode(pathMgr *pathMgr, path string, data []b
 
 		// if the register path is empty or it doesn't exist， create it
 		blog.Infof("loop register node at path:%s", path)
-		registerPath, err := zkRD.zkcli.CreateEphAndSeqEx(path, data)
+		registerPath, err := zkRD.createProtectedEphAndSeq(path, data)
 		if err != nil {
 			blog.Errorf("fail to register server node(%s). CreateEphAndSeqEx err:%s", path, err.Error())
 			if zkRD.zkcli.IsConnectionError(err) {
@@ -225,17 +386,7 @@ func (zkRD *ZkRegDiscv) Discover(path string) (<-chan *DiscoverEvent, error) {
 		var oldServer map[string]bool
 		for {
 			event := zkRD.getServerInfoByPath(path)
-			isUpdated := false
-			newServer := make(map[string]bool)
-			if len(event.Server) != len(oldServer) {
-				isUpdated = true
-			}
-			for _, server := range event.Server {
-				if !isUpdated && !oldServer[server] {
-					isUpdated = true
-				}
-				newServer[server] = true
-			}
+			newServer, isUpdated := diffServer(oldServer, event)
 			oldServer = newServer
 			if isUpdated {
 				env <- event
@@ -353,7 +504,7 @@ func (zkRD *ZkRegDiscv) reconnectZk() {
 	defer zkRD.Unlock()
 
 	for {
-		if zkRD.zkcli.ZkConn != nil && zkRD.zkcli.Ping() == nil {
+		if zkRD.zkcli.Conn() != nil && zkRD.zkcli.Ping() == nil {
 			blog.Info("connection is healthy, there is no need to reconnect, **skip**")
 			return
 		}
@@ -367,10 +518,39 @@ func (zkRD *ZkRegDiscv) reconnectZk() {
 		time.Sleep(time.Second)
 		fmt.Println("reconnect zookeeper success")
 
+		if err := zkRD.applyAuth(); err != nil {
+			// the session is usable even without auth applied, an unauthenticated
+			// client just won't be able to touch ACL-protected paths, so we log
+			// and move on instead of looping the reconnect forever over this.
+			blog.Errorf("reconnect zookeeper succeeded but failed to re-apply auth, err:%s", err.Error())
+		}
+
 		return
 	}
 }
 
+// applyAuth adds this instance's configured credential to the current zk session. zk
+// ACLs are enforced per session, not per connection, so this must run again every
+// time the session is recreated, it is a no-op if SetAuth was never called, and a
+// no-op if the session isn't connected yet (SetAuth/RegisterAndWatchWithACL may run
+// before the very first connect, reconnectZk applies it once one is established).
+func (zkRD *ZkRegDiscv) applyAuth() error {
+	zkRD.credMu.RLock()
+	auth := zkRD.auth
+	zkRD.credMu.RUnlock()
+
+	if auth == nil {
+		return nil
+	}
+
+	conn := zkRD.zkcli.Conn()
+	if conn == nil {
+		return nil
+	}
+
+	return conn.AddAuth(auth.Scheme, auth.Credential)
+}
+
 func (zkRD *ZkRegDiscv) sortNode(nodes []string) []string {
 	var sortPart []int
 	mapSortNode := make(map[int]string)
@@ -412,7 +592,80 @@ func (zkRD *ZkRegDiscv) ClearRegisterPath() error {
 		if err = zkRD.zkcli.Del(k.(string), -1); err != nil {
 			return false
 		}
+		// only forget the path once it's actually gone from zk, so that if Range
+		// aborts on a later path's error, the paths already cleared here don't
+		// linger in registeredPathsMap and get deleted again for nothing next time.
+		zkRD.registeredPathsMap.Delete(k)
 		return true
 	})
 	return err
 }
+
+// ClearRegisterPathWithACLCheck behaves like ClearRegisterPath, but before deleting
+// each registered path it walks that path's parents and repairs any ACL that has
+// drifted from this instance's configured acl, so a parent left over from before ACLs
+// were enabled (or touched by another, misconfigured client) doesn't silently keep
+// the tree open. it's a no-op repair pass if RegisterAndWatchWithACL was never used,
+// since acl is empty in that case (SetAuth alone does not set acl).
+func (zkRD *ZkRegDiscv) ClearRegisterPathWithACLCheck() error {
+	zkRD.credMu.RLock()
+	acl := zkRD.acl
+	zkRD.credMu.RUnlock()
+
+	if len(acl) > 0 {
+		var repairErr error
+		zkRD.registeredPathsMap.Range(func(k, v interface{}) bool {
+			if repairErr = zkRD.repairParentACL(k.(string), acl); repairErr != nil {
+				return false
+			}
+			return true
+		})
+		if repairErr != nil {
+			return repairErr
+		}
+	}
+
+	return zkRD.ClearRegisterPath()
+}
+
+// repairParentACL walks path's parents from root down to (not including) path itself,
+// and resets any parent whose current ACL doesn't match acl.
+func (zkRD *ZkRegDiscv) repairParentACL(path string, acl []gozk.ACL) error {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	parent := ""
+	for _, seg := range segments[:len(segments)-1] {
+		parent += "/" + seg
+
+		current, _, err := zkRD.zkcli.GetACL(parent)
+		if err != nil {
+			return err
+		}
+		if aclEqual(current, acl) {
+			continue
+		}
+
+		blog.Infof("acl of path(%s) has drifted, repairing it", parent)
+		if _, err := zkRD.zkcli.SetACL(parent, acl, -1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// aclEqual reports whether two ACL lists grant the exact same set of permissions,
+// order doesn't matter since zookeeper doesn't guarantee one.
+func aclEqual(a, b []gozk.ACL) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	want := make(map[gozk.ACL]struct{}, len(b))
+	for _, entry := range b {
+		want[entry] = struct{}{}
+	}
+	for _, entry := range a {
+		if _, ok := want[entry]; !ok {
+			return false
+		}
+	}
+	return true
+}
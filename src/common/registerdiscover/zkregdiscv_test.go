@@ -0,0 +1,277 @@
+/*
+ * Tencent is pleased to support the open source community by making 蓝鲸 available.
+ * Copyright (C) 2017-2018 THL A29 Limited, a Tencent company. All rights reserved.
+ * Licensed under the MIT License (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ * http://opensource.org/licenses/MIT
+ * Unless required by applicable law or agreed to in writing, software distributed under
+ * the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+ * either express or implied. See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registerdiscover
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	gozk "github.com/samuel/go-zookeeper/zk"
+)
+
+// fakeZkClient is a minimal, in-memory ZkClient used to unit test ZkRegDiscv without
+// a live zookeeper. only the methods a given test cares about need behavior, the rest
+// return harmless zero values.
+type fakeZkClient struct {
+	children map[string][]string
+
+	createErr  error
+	createPath string
+
+	existErr error
+	exist    bool
+
+	isConnErr func(error) bool
+
+	watchChildrenErr   error
+	watchChildrenCh    chan gozk.Event
+	watchChildrenCalls int
+
+	delErr   error
+	delCalls []string
+
+	connectExHook func()
+}
+
+func (f *fakeZkClient) CreateEphAndSeqEx(path string, data []byte) (string, error) {
+	if f.createErr != nil {
+		return "", f.createErr
+	}
+	return f.createPath, nil
+}
+
+func (f *fakeZkClient) CreateEphAndSeqExWithACL(path string, data []byte, acl []gozk.ACL) (string, error) {
+	return f.CreateEphAndSeqEx(path, data)
+}
+
+func (f *fakeZkClient) ExistW(path string) (bool, *gozk.Stat, <-chan gozk.Event, error) {
+	return f.exist, nil, nil, f.existErr
+}
+
+func (f *fakeZkClient) Exist(path string) (bool, error) {
+	return f.exist, f.existErr
+}
+
+func (f *fakeZkClient) GetChildren(path string) ([]string, error) {
+	return f.children[path], nil
+}
+
+func (f *fakeZkClient) Get(path string) (string, error) {
+	return "", nil
+}
+
+func (f *fakeZkClient) Del(path string, version int32) error {
+	f.delCalls = append(f.delCalls, path)
+	if f.delErr != nil && path == f.createPath {
+		return f.delErr
+	}
+	return nil
+}
+
+func (f *fakeZkClient) Ping() error {
+	return nil
+}
+
+func (f *fakeZkClient) ConnectEx(_ time.Duration) error {
+	if f.connectExHook != nil {
+		f.connectExHook()
+	}
+	return nil
+}
+
+func (f *fakeZkClient) IsConnectionError(err error) bool {
+	if f.isConnErr != nil {
+		return f.isConnErr(err)
+	}
+	return false
+}
+
+func (f *fakeZkClient) WatchChildren(path string) ([]string, <-chan gozk.Event, error) {
+	f.watchChildrenCalls++
+	// only fail the first call, so the test's loopDiscover exercises exactly one
+	// reconnect and then settles instead of spinning on a connection error forever.
+	if f.watchChildrenCalls == 1 {
+		return nil, nil, f.watchChildrenErr
+	}
+	return f.children[path], f.watchChildrenCh, nil
+}
+
+func (f *fakeZkClient) GetACL(path string) ([]gozk.ACL, *gozk.Stat, error) {
+	return nil, nil, nil
+}
+
+func (f *fakeZkClient) SetACL(path string, acl []gozk.ACL, version int32) (*gozk.Stat, error) {
+	return nil, nil
+}
+
+func (f *fakeZkClient) Conn() *gozk.Conn {
+	return nil
+}
+
+func newTestZkRegDiscv(cli ZkClient) *ZkRegDiscv {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ZkRegDiscv{
+		zkcli:          cli,
+		rootCxt:        ctx,
+		cancel:         cancel,
+		registrationID: "_c_test-guid-",
+	}
+}
+
+// TestCreateProtectedEphAndSeq_AdoptsGhostNode covers the ghost-node dedup case: the
+// create call reports an error, but a node carrying this instance's registrationID is
+// already there (the create actually succeeded server-side, only the reply was lost),
+// so it must be adopted instead of retried into a duplicate node.
+func TestCreateProtectedEphAndSeq_AdoptsGhostNode(t *testing.T) {
+	const parent = "/cc/services/svc"
+	const ownNode = "_c_test-guid-0000000001"
+
+	fake := &fakeZkClient{
+		createErr: errors.New("connection reset while waiting for reply"),
+		children:  map[string][]string{parent: {"some-other-node-0000000002", ownNode}},
+	}
+	zkRD := newTestZkRegDiscv(fake)
+
+	// the node is created from parent+"/"+registrationID, i.e. as a child of parent.
+	got, err := zkRD.createProtectedEphAndSeq(parent+"/", []byte("data"))
+	if err != nil {
+		t.Fatalf("expected the ghost node to be adopted without error, got: %v", err)
+	}
+
+	want := parent + "/" + ownNode
+	if got != want {
+		t.Errorf("adopted path = %q, want %q", got, want)
+	}
+}
+
+// TestCreateProtectedEphAndSeq_NoGhostNode covers the case where the create call
+// really did fail: no node with this instance's registrationID exists, so the
+// original error must be returned instead of silently adopting someone else's node.
+func TestCreateProtectedEphAndSeq_NoGhostNode(t *testing.T) {
+	const parent = "/cc/services/svc"
+
+	wantErr := errors.New("connection reset while waiting for reply")
+	fake := &fakeZkClient{
+		createErr: wantErr,
+		children:  map[string][]string{parent: {"some-other-node-0000000002"}},
+	}
+	zkRD := newTestZkRegDiscv(fake)
+
+	if _, err := zkRD.createProtectedEphAndSeq(parent+"/", []byte("data")); err != wantErr {
+		t.Errorf("err = %v, want %v", err, wantErr)
+	}
+}
+
+// TestLoopDiscover_ReconnectsOnConnectionError covers the reconnect path inside
+// loopDiscover: when WatchChildren reports a connection error, loopDiscover must
+// recognize it via IsConnectionError and loop back around to retry, instead of
+// treating it like any other watch failure.
+func TestLoopDiscover_ReconnectsOnConnectionError(t *testing.T) {
+	const path = "/cc/services/svc"
+	connErr := errors.New("connection is closed")
+
+	var reconnectAttempts int
+	fake := &fakeZkClient{
+		watchChildrenErr: connErr,
+		isConnErr:        func(err error) bool { return err == connErr },
+	}
+	zkRD := newTestZkRegDiscv(fake)
+	fake.connectExHook = func() {
+		reconnectAttempts++
+		zkRD.cancel()
+	}
+
+	env := make(chan *DiscoverEvent, 1)
+	zkRD.loopDiscover(zkRD.rootCxt, path, env)
+
+	if reconnectAttempts == 0 {
+		t.Error("expected loopDiscover to trigger at least one reconnect attempt on a connection error")
+	}
+}
+
+// TestSortNode covers sortNode's handling of node names that are too short to carry a
+// 10-digit sequence suffix: they are silently dropped from the sorted result rather
+// than erroring, since there's nothing valid to sort them by.
+func TestSortNode(t *testing.T) {
+	zkRD := newTestZkRegDiscv(&fakeZkClient{})
+
+	nodes := []string{
+		"short",                   // 5 chars, < 10, dropped
+		"exactly_10",              // 10 chars, <= 10, dropped
+		"node-name-0000000002",    // 20 chars, seq 2
+		"node-name-0000000001",    // 20 chars, seq 1
+		"not-a-number-abcdefghij", // last 10 chars aren't numeric, dropped
+	}
+
+	got := zkRD.sortNode(nodes)
+	want := []string{"node-name-0000000001", "node-name-0000000002"}
+
+	if len(got) != len(want) {
+		t.Fatalf("sortNode() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("sortNode()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+// TestClearRegisterPath_AbortDoesNotLeakClearedEntries covers ClearRegisterPath
+// aborting partway through registeredPathsMap: whatever path it already deleted from
+// zk before hitting the error must also be forgotten locally, so a later retry only
+// ever re-attempts the paths that are genuinely still registered.
+func TestClearRegisterPath_AbortDoesNotLeakClearedEntries(t *testing.T) {
+	const badPath = "/cc/services/svc/bad-0000000001"
+	goodPaths := []string{
+		"/cc/services/svc/good-0000000002",
+		"/cc/services/svc/good-0000000003",
+		"/cc/services/svc/good-0000000004",
+		"/cc/services/svc/good-0000000005",
+	}
+
+	delErr := errors.New("zk: connection closed")
+	fake := &fakeZkClient{
+		createPath: badPath,
+		delErr:     delErr,
+	}
+	zkRD := newTestZkRegDiscv(fake)
+	zkRD.registeredPathsMap.Store(badPath, struct{}{})
+	for _, p := range goodPaths {
+		zkRD.registeredPathsMap.Store(p, struct{}{})
+	}
+
+	err := zkRD.ClearRegisterPath()
+	if err != delErr {
+		t.Fatalf("ClearRegisterPath() err = %v, want %v", err, delErr)
+	}
+
+	// badPath's Del failed, so it must still be tracked for the next attempt.
+	if _, ok := zkRD.registeredPathsMap.Load(badPath); !ok {
+		t.Error("badPath should still be registered after its own Del failed")
+	}
+
+	// any path whose Del actually succeeded must be forgotten, even though Range
+	// aborted on badPath, so it isn't leaked in registeredPathsMap forever. sync.Map
+	// doesn't guarantee iteration order, so how many of goodPaths were reached before
+	// the abort varies, but every one that was must not linger.
+	for _, deleted := range fake.delCalls {
+		if deleted == badPath {
+			continue
+		}
+		if _, ok := zkRD.registeredPathsMap.Load(deleted); ok {
+			t.Errorf("path(%s) was successfully deleted from zk but is still tracked in registeredPathsMap", deleted)
+		}
+	}
+}
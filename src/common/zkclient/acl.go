@@ -0,0 +1,37 @@
+/*
+ * Tencent is pleased to support the open source community by making 蓝鲸 available.
+ * Copyright (C) 2017-2018 THL A29 Limited, a Tencent company. All rights reserved.
+ * Licensed under the MIT License (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ * http://opensource.org/licenses/MIT
+ * Unless required by applicable law or agreed to in writing, software distributed under
+ * the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+ * either express or implied. See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zkclient
+
+import (
+	gozk "github.com/samuel/go-zookeeper/zk"
+)
+
+// CreateEphAndSeqExWithACL creates an ephemeral sequential node like CreateEphAndSeqEx,
+// but through the connection's CreateProtectedEphemeralSequential, and the created node
+// carries acl instead of zookeeper's world-open default ACL. registerdiscover.ZkRegDiscv
+// calls it in place of CreateEphAndSeqEx once an ACL is set through
+// RegisterAndWatchWithACL; an AuthConfig alone does not switch to it.
+func (c *ZkClient) CreateEphAndSeqExWithACL(path string, data []byte, acl []gozk.ACL) (string, error) {
+	return c.ZkConn.CreateProtectedEphemeralSequential(path, data, acl)
+}
+
+// GetACL returns the ACL currently set on path.
+func (c *ZkClient) GetACL(path string) ([]gozk.ACL, *gozk.Stat, error) {
+	return c.ZkConn.GetACL(path)
+}
+
+// SetACL replaces the ACL set on path, version mirrors the znode version to update, -1
+// to match any.
+func (c *ZkClient) SetACL(path string, acl []gozk.ACL, version int32) (*gozk.Stat, error) {
+	return c.ZkConn.SetACL(path, acl, version)
+}
@@ -0,0 +1,23 @@
+/*
+ * Tencent is pleased to support the open source community by making 蓝鲸 available.
+ * Copyright (C) 2017-2018 THL A29 Limited, a Tencent company. All rights reserved.
+ * Licensed under the MIT License (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ * http://opensource.org/licenses/MIT
+ * Unless required by applicable law or agreed to in writing, software distributed under
+ * the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+ * either express or implied. See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zkclient
+
+import (
+	gozk "github.com/samuel/go-zookeeper/zk"
+)
+
+// Conn exposes the underlying zookeeper connection, for callers that need a call with
+// no higher-level wrapper on ZkClient itself, such as AddAuth or a raw liveness check.
+func (c *ZkClient) Conn() *gozk.Conn {
+	return c.ZkConn
+}
@@ -0,0 +1,76 @@
+/*
+ * Tencent is pleased to support the open source community by making 蓝鲸 available.
+ * Copyright (C) 2017-2018 THL A29 Limited, a Tencent company. All rights reserved.
+ * Licensed under the MIT License (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ * http://opensource.org/licenses/MIT
+ * Unless required by applicable law or agreed to in writing, software distributed under
+ * the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+ * either express or implied. See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package stream
+
+import (
+	"time"
+
+	"configcenter/src/common/blog"
+	"configcenter/src/storage/stream/types"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// checkpointer rate-limits how often a watcher actually hits TokenStore.Save, so a
+// busy watcher doesn't write to TokenCollection on every single event. the change
+// stream loop calls Checkpoint once per event, in event/clusterTime order, right after
+// it has queued that event on Watcher.EventChan. a saved token therefore does not mean
+// the consumer has read the event: events still buffered in EventChan when the
+// consumer stops are not replayed on restart once their token has been saved.
+type checkpointer struct {
+	opts *types.WatchOptions
+
+	sinceEvents int
+	lastSaved   time.Time
+}
+
+// newCheckpointer resolves opts.StartAfterToken from opts.TokenStore's last saved
+// checkpoint, if the caller didn't already set one explicitly. it's meant to be called
+// once, right before a Watcher is constructed from opts.
+func newCheckpointer(opts *types.WatchOptions) (*checkpointer, error) {
+	cp := &checkpointer{opts: opts, lastSaved: time.Now()}
+
+	if opts.TokenStore == nil || opts.StartAfterToken != nil {
+		return cp, nil
+	}
+
+	token, err := opts.TokenStore.Load(opts.WatcherKey)
+	if err != nil {
+		return nil, err
+	}
+	opts.StartAfterToken = token
+
+	return cp, nil
+}
+
+// Checkpoint persists token as opts.WatcherKey's resume point once enough events or
+// enough time has passed since the last checkpoint, it's a no-op otherwise and a no-op
+// entirely if opts.TokenStore is unset.
+func (cp *checkpointer) Checkpoint(token *types.EventToken, clusterTime primitive.Timestamp) {
+	if cp.opts.TokenStore == nil {
+		return
+	}
+
+	cp.sinceEvents++
+	if cp.sinceEvents < cp.opts.CheckpointEvents && time.Since(cp.lastSaved) < cp.opts.CheckpointInterval {
+		return
+	}
+
+	if err := cp.opts.TokenStore.Save(cp.opts.WatcherKey, token, clusterTime); err != nil {
+		blog.Errorf("fail to checkpoint watcher(%s) token, err: %v", cp.opts.WatcherKey, err)
+		return
+	}
+
+	cp.sinceEvents = 0
+	cp.lastSaved = time.Now()
+}
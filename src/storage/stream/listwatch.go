@@ -0,0 +1,280 @@
+/*
+ * Tencent is pleased to support the open source community by making 蓝鲸 available.
+ * Copyright (C) 2017-2018 THL A29 Limited, a Tencent company. All rights reserved.
+ * Licensed under the MIT License (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ * http://opensource.org/licenses/MIT
+ * Unless required by applicable law or agreed to in writing, software distributed under
+ * the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+ * either express or implied. See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package stream
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"configcenter/src/common/blog"
+	"configcenter/src/storage/stream/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// shardedLister runs a ListWatchOptions' list phase across opts.Shards concurrent
+// cursors, one per "_id" timestamp range, and merges their documents into eventChan
+// as types.Lister events. a single types.ListDone event is emitted once every shard
+// has drained, never per-shard, so callers keep seeing exactly the same "list done,
+// now watching" signal they saw with a single cursor.
+type shardedLister struct {
+	col        *mongo.Collection
+	opts       *types.ListWatchOptions
+	store      types.TokenStore
+	watcherKey string
+}
+
+func newShardedLister(col *mongo.Collection, opts *types.ListWatchOptions, store types.TokenStore,
+	watcherKey string) *shardedLister {
+
+	return &shardedLister{
+		col:        col,
+		opts:       opts,
+		store:      store,
+		watcherKey: watcherKey,
+	}
+}
+
+// Run lists the collection through *opts.Shards concurrent cursors and sends every
+// document as a types.Lister event on eventChan, followed by a single types.ListDone
+// once all shards have drained.
+func (s *shardedLister) Run(ctx context.Context, eventChan chan *types.Event) error {
+	shards := *s.opts.Shards
+	if shards <= 1 {
+		if err := s.runShard(ctx, 0, nil, nil, eventChan); err != nil {
+			return err
+		}
+		eventChan <- &types.Event{OperationType: types.ListDone}
+		return nil
+	}
+
+	boundaries, err := s.shardBoundaries(ctx, shards)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, shards)
+	for shard := 0; shard < shards; shard++ {
+		wg.Add(1)
+		go func(shard int) {
+			defer wg.Done()
+			if err := s.runShard(ctx, shard, &boundaries[shard], &boundaries[shard+1], eventChan); err != nil {
+				errCh <- err
+			}
+		}(shard)
+	}
+	wg.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		if err != nil {
+			return err
+		}
+	}
+
+	eventChan <- &types.Event{OperationType: types.ListDone}
+	return nil
+}
+
+// shardBoundaries splits the collection's "_id" range, from its lowest to its highest
+// document, into shards equal-width timestamp buckets, returning shards+1 boundary
+// ObjectIDs so that shard i covers [boundaries[i], boundaries[i+1]).
+func (s *shardedLister) shardBoundaries(ctx context.Context, shards int) ([]primitive.ObjectID, error) {
+	var first, last bson.M
+
+	err := s.col.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.M{"_id": 1})).Decode(&first)
+	if err == mongo.ErrNoDocuments {
+		// empty collection, every shard gets the same, empty range.
+		boundaries := make([]primitive.ObjectID, shards+1)
+		return boundaries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.col.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.M{"_id": -1})).Decode(&last); err != nil {
+		return nil, err
+	}
+
+	minTS, maxTS := first["_id"].(primitive.ObjectID).Timestamp(), last["_id"].(primitive.ObjectID).Timestamp()
+	// +1s so the last boundary's upper bound is exclusive of, but still covers, maxTS.
+	maxTS = maxTS.Add(time.Second)
+	step := maxTS.Sub(minTS) / time.Duration(shards)
+
+	boundaries := make([]primitive.ObjectID, shards+1)
+	for i := 0; i <= shards; i++ {
+		t := minTS.Add(step * time.Duration(i))
+		if i == shards {
+			t = maxTS
+		}
+		boundaries[i] = primitive.NewObjectIDFromTimestamp(t)
+	}
+	return boundaries, nil
+}
+
+// runShard lists this shard's range of documents and sends each as a types.Lister
+// event on eventChan, checkpointing the highest "_id" it has sent so far so that a
+// restart during the list phase can resume this shard instead of re-reading it whole.
+func (s *shardedLister) runShard(ctx context.Context, shard int, lower, upper *primitive.ObjectID,
+	eventChan chan *types.Event) error {
+
+	// the checkpoint key carries the actual boundary values this shard covers, not
+	// just its bare index: shardBoundaries recomputes boundaries from the
+	// collection's current min/max "_id" on every call, so if that range has grown
+	// since the last run (or Shards changed), shard N's range today may not be the
+	// same one shard N covered before. keying by the boundaries makes that shift
+	// detectable instead of silently resuming a "$gt lastID" filter against a range
+	// it was never checkpointed against: a shifted range simply misses the old
+	// checkpoint and relists its (new) range in full, rather than risk skipping
+	// documents a stale checkpoint was never actually positioned to cover.
+	watcherKey := fmt.Sprintf("%s-shard-%d-%s-%s", s.watcherKey, shard, oidOrFull(lower), oidOrFull(upper))
+
+	filter := bson.M{}
+	if lower != nil && upper != nil {
+		filter["_id"] = bson.M{"$gte": *lower, "$lt": *upper}
+	}
+
+	if s.store != nil {
+		if token, err := s.store.Load(watcherKey); err != nil {
+			blog.Errorf("fail to load list checkpoint for shard(%d), err: %v", shard, err)
+		} else if token != nil {
+			if oid, err := primitive.ObjectIDFromHex(token.Data); err == nil {
+				idFilter, _ := filter["_id"].(bson.M)
+				if idFilter == nil {
+					idFilter = bson.M{}
+				}
+				idFilter["$gt"] = oid
+				filter["_id"] = idFilter
+			}
+		}
+	}
+
+	cursor, err := s.col.Find(ctx, filter,
+		options.Find().SetBatchSize(int32(*s.opts.PageSize)).SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var lastID primitive.ObjectID
+	var sinceCheckpoint int
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+
+		oid, _ := doc["_id"].(primitive.ObjectID)
+		lastID = oid
+
+		s.sendWithBackpressure(ctx, eventChan, &types.Event{
+			Oid:           oid.Hex(),
+			Document:      doc,
+			OperationType: types.Lister,
+		})
+
+		sinceCheckpoint++
+		if s.store != nil && sinceCheckpoint >= *s.opts.PageSize {
+			if err := s.store.Save(watcherKey, &types.EventToken{Data: lastID.Hex()}, primitive.Timestamp{}); err != nil {
+				blog.Errorf("fail to checkpoint list shard(%d) at _id(%s), err: %v", shard, lastID.Hex(), err)
+			}
+			sinceCheckpoint = 0
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	if s.store != nil && sinceCheckpoint > 0 {
+		if err := s.store.Save(watcherKey, &types.EventToken{Data: lastID.Hex()}, primitive.Timestamp{}); err != nil {
+			blog.Errorf("fail to checkpoint list shard(%d) at _id(%s), err: %v", shard, lastID.Hex(), err)
+		}
+	}
+
+	return nil
+}
+
+// oidOrFull renders oid as a hex string for use in a checkpoint key, or "full" when
+// oid is nil (the single, unsharded shard that covers the whole collection).
+func oidOrFull(oid *primitive.ObjectID) string {
+	if oid == nil {
+		return "full"
+	}
+	return oid.Hex()
+}
+
+// sendWithBackpressure sends event on eventChan, blocking the producer whenever the
+// channel is more than 80% full instead of piling events up without bound, so a slow
+// consumer slows this shard down instead of making the process OOM.
+func (s *shardedLister) sendWithBackpressure(ctx context.Context, eventChan chan *types.Event, event *types.Event) {
+	for len(eventChan) > (cap(eventChan)*8)/10 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+	case eventChan <- event:
+	}
+}
+
+// NewListWatch lists col's documents, sharded across opts.Shards concurrent cursors
+// when it's set above 1 (see shardedLister), then flips to watching col's change stream
+// for further changes, through a single, shared Watcher.EventChan. store and
+// watcherKey are used both for the list phase's per-shard checkpoints and, once the
+// list phase's types.ListDone fires, for the watch phase's resume token, exactly like
+// NewWatcher.
+func NewListWatch(ctx context.Context, col *mongo.Collection, opts *types.ListWatchOptions, store types.TokenStore,
+	watcherKey string) (*types.Watcher, error) {
+
+	if err := opts.CheckSetDefault(); err != nil {
+		return nil, err
+	}
+
+	watchOpts := &types.WatchOptions{
+		Options:    opts.Options,
+		WatcherKey: watcherKey,
+		TokenStore: store,
+	}
+	if err := watchOpts.CheckSetDefault(); err != nil {
+		return nil, err
+	}
+
+	cp, err := newCheckpointer(watchOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	lister := newShardedLister(col, opts, store, watcherKey)
+	eventChan := make(chan *types.Event, types.DefaultEventChanSize)
+
+	go func() {
+		if err := lister.Run(ctx, eventChan); err != nil {
+			blog.Errorf("list phase for watcher(%s) failed, err: %v", watcherKey, err)
+			close(eventChan)
+			return
+		}
+		runWatchLoop(ctx, col, watchOpts, cp, eventChan)
+	}()
+
+	return &types.Watcher{EventChan: eventChan}, nil
+}
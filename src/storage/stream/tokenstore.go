@@ -0,0 +1,101 @@
+/*
+ * Tencent is pleased to support the open source community by making 蓝鲸 available.
+ * Copyright (C) 2017-2018 THL A29 Limited, a Tencent company. All rights reserved.
+ * Licensed under the MIT License (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ * http://opensource.org/licenses/MIT
+ * Unless required by applicable law or agreed to in writing, software distributed under
+ * the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+ * either express or implied. See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package stream
+
+import (
+	"context"
+	"time"
+
+	"configcenter/src/storage/stream/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TokenCollection is where MongoTokenStore checkpoints every watcher's resume token.
+const TokenCollection = "cc_WatchTokens"
+
+// var _ types.TokenStore = (*MongoTokenStore)(nil) asserts MongoTokenStore satisfies
+// the TokenStore contract.
+var _ types.TokenStore = (*MongoTokenStore)(nil)
+
+// MongoTokenStore is the default types.TokenStore implementation, it upserts
+// checkpoints into TokenCollection in the same mongodb that's being watched.
+type MongoTokenStore struct {
+	col *mongo.Collection
+}
+
+// NewMongoTokenStore creates a MongoTokenStore that checkpoints into db's TokenCollection.
+func NewMongoTokenStore(db *mongo.Database) *MongoTokenStore {
+	return &MongoTokenStore{
+		col: db.Collection(TokenCollection),
+	}
+}
+
+// Load returns the last checkpointed token for watcherKey, nil if none was saved yet.
+func (m *MongoTokenStore) Load(watcherKey string) (*types.EventToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	checkpoint := new(types.WatchTokenCheckpoint)
+	err := m.col.FindOne(ctx, bson.M{"watcher_key": watcherKey}).Decode(checkpoint)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &checkpoint.Token, nil
+}
+
+// LoadClusterTime returns the cluster time recorded alongside watcherKey's last
+// checkpointed token, so a rejected (too old) token can fall back to
+// startAtOperationTime instead of resetting the watch to "now".
+func (m *MongoTokenStore) LoadClusterTime(watcherKey string) (*primitive.Timestamp, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	checkpoint := new(types.WatchTokenCheckpoint)
+	err := m.col.FindOne(ctx, bson.M{"watcher_key": watcherKey}).Decode(checkpoint)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &checkpoint.ClusterTime, nil
+}
+
+// Save checkpoints token as watcherKey's resume point, along with the cluster time it
+// was observed at.
+func (m *MongoTokenStore) Save(watcherKey string, token *types.EventToken, clusterTime primitive.Timestamp) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	checkpoint := types.WatchTokenCheckpoint{
+		WatcherKey:  watcherKey,
+		Token:       *token,
+		ClusterTime: clusterTime,
+	}
+
+	_, err := m.col.UpdateOne(ctx,
+		bson.M{"watcher_key": watcherKey},
+		bson.M{"$set": checkpoint},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
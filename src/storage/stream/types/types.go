@@ -81,7 +81,7 @@ var defaultMaxAwaitTime = time.Second
 func (opts *Options) CheckSetDefault() error {
 	if reflect.ValueOf(opts.EventStruct).Kind() != reflect.Ptr ||
 		reflect.ValueOf(opts.EventStruct).IsNil() {
-		fmt.Errorf("invalid EventStruct field, must be a none pointer")
+		return fmt.Errorf("invalid EventStruct field, must be a non-nil pointer")
 	}
 
 	if opts.MajorityCommitted == nil {
@@ -104,7 +104,82 @@ type WatchOptions struct {
 	// StartAfterToken describe where you want to watch the event.
 	// Note: the returned event does'nt contains the token represented,
 	// and will returns event just after this token.
+	// if it's nil and TokenStore is set, it's auto-populated from the store's last
+	// checkpoint for WatcherKey.
 	StartAfterToken *EventToken
+
+	// WatcherKey identifies this watcher's checkpoint in TokenStore, it must be
+	// stable across process restarts of the same logical consumer.
+	// Note: required if TokenStore is set.
+	WatcherKey string
+
+	// TokenStore, when set, checkpoints this watcher's resume token as events are
+	// queued on EventChan (not when the consumer reads them), and auto-populates
+	// StartAfterToken from the last checkpoint at construction time so a consumer
+	// restart resumes from that checkpoint.
+	TokenStore TokenStore
+
+	// CheckpointEvents checkpoints the token every this many queued events.
+	// default value is `DefaultCheckpointEvents`.
+	CheckpointEvents int
+
+	// CheckpointInterval checkpoints the token after this much time passed since
+	// the last checkpoint, regardless of event count.
+	// default value is `DefaultCheckpointInterval`.
+	CheckpointInterval time.Duration
+}
+
+var (
+	// DefaultCheckpointEvents is how many queued events a watcher checkpoints its
+	// resume token after, when WatchOptions.CheckpointEvents is unset.
+	DefaultCheckpointEvents = 200
+	// DefaultCheckpointInterval is how long a watcher waits since its last
+	// checkpoint before checkpointing again, when WatchOptions.CheckpointInterval
+	// is unset.
+	DefaultCheckpointInterval = 5 * time.Second
+)
+
+// CheckSetDefault check the legal of each option, and set the default value
+func (w *WatchOptions) CheckSetDefault() error {
+	if err := w.Options.CheckSetDefault(); err != nil {
+		return err
+	}
+
+	if w.TokenStore != nil && len(w.WatcherKey) == 0 {
+		return errors.New("invalid WatcherKey field, can not be empty when TokenStore is set")
+	}
+
+	if w.CheckpointEvents <= 0 {
+		w.CheckpointEvents = DefaultCheckpointEvents
+	}
+
+	if w.CheckpointInterval <= 0 {
+		w.CheckpointInterval = DefaultCheckpointInterval
+	}
+
+	return nil
+}
+
+// TokenStore persists and retrieves a watcher's resume checkpoint, so that a consumer
+// restart (crash, deploy, etc.) resumes from its last known position instead of
+// silently losing every event in between.
+type TokenStore interface {
+	// Load returns the last checkpointed token for watcherKey, nil if none was
+	// saved yet.
+	Load(watcherKey string) (*EventToken, error)
+	// Save checkpoints token as watcherKey's resume point. clusterTime is the
+	// server time the token was observed at, it's persisted alongside the token
+	// so that if the token is later rejected by the server as too old
+	// (ChangeStreamHistoryLost), the watcher can fall back to
+	// startAtOperationTime using clusterTime instead of resetting to "now".
+	Save(watcherKey string, token *EventToken, clusterTime primitive.Timestamp) error
+}
+
+// WatchTokenCheckpoint is what TokenStore persists for a single watcherKey.
+type WatchTokenCheckpoint struct {
+	WatcherKey  string              `bson:"watcher_key"`
+	Token       EventToken          `bson:"token"`
+	ClusterTime primitive.Timestamp `bson:"cluster_time"`
 }
 
 var defaultListPageSize = 1000
@@ -115,8 +190,18 @@ type ListWatchOptions struct {
 	// Step defines the list step when the client try to list all the data defines in the
 	// namespace. default value is `DefaultListStep`, value range [200,2000]
 	PageSize *int
+
+	// Shards defines how many concurrent cursors the list phase is partitioned
+	// into, by splitting the collection's "_id" range into that many timestamp
+	// buckets. default value is 1, meaning the list phase runs as a single cursor
+	// like before.
+	// Note: a large collection with Shards == 1 stalls the watch phase behind the
+	// whole list phase, set Shards > 1 to fan the list phase out instead.
+	Shards *int
 }
 
+var defaultShards = 1
+
 func (lw *ListWatchOptions) CheckSetDefault() error {
 	if err := lw.Options.CheckSetDefault(); err != nil {
 		return err
@@ -130,6 +215,14 @@ func (lw *ListWatchOptions) CheckSetDefault() error {
 		lw.PageSize = &defaultListPageSize
 	}
 
+	if lw.Shards != nil {
+		if *lw.Shards < 1 {
+			return fmt.Errorf("invalid shards, must be >= 1")
+		}
+	} else {
+		lw.Shards = &defaultShards
+	}
+
 	return nil
 }
 
@@ -0,0 +1,181 @@
+/*
+ * Tencent is pleased to support the open source community by making 蓝鲸 available.
+ * Copyright (C) 2017-2018 THL A29 Limited, a Tencent company. All rights reserved.
+ * Licensed under the MIT License (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ * http://opensource.org/licenses/MIT
+ * Unless required by applicable law or agreed to in writing, software distributed under
+ * the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+ * either express or implied. See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package stream
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"configcenter/src/common/blog"
+	"configcenter/src/storage/stream/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// changeStreamHistoryLost is the mongodb server error code returned when a requested
+// resume point (a token or an operation time) has aged out of the oplog.
+const changeStreamHistoryLost = 286
+
+// NewWatcher opens a mongodb change stream against col according to opts and starts
+// forwarding its events on the returned Watcher's EventChan, until ctx is done. if
+// opts.TokenStore is set, opts.StartAfterToken is first resolved from the store's last
+// checkpoint (unless the caller already set one explicitly), and the token of every
+// event queued on EventChan is checkpointed back to the store at
+// opts.CheckpointEvents/opts.CheckpointInterval cadence, so a consumer restart resumes
+// from the last saved token. a token is saved once its event is queued, not once the
+// consumer has read it (see checkpointer).
+func NewWatcher(ctx context.Context, col *mongo.Collection, opts *types.WatchOptions) (*types.Watcher, error) {
+	if err := opts.CheckSetDefault(); err != nil {
+		return nil, err
+	}
+
+	cp, err := newCheckpointer(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	eventChan := make(chan *types.Event, types.DefaultEventChanSize)
+	go runWatchLoop(ctx, col, opts, cp, eventChan)
+
+	return &types.Watcher{EventChan: eventChan}, nil
+}
+
+// runWatchLoop drives col's change stream until ctx is done, decoding every change
+// event as a types.Event on eventChan and checkpointing its token through cp. if the
+// server rejects the resume point with ChangeStreamHistoryLost, it falls back to
+// starting at the cluster time of the last checkpoint instead of resetting to "now"
+// and silently dropping whatever happened since.
+func runWatchLoop(ctx context.Context, col *mongo.Collection, opts *types.WatchOptions, cp *checkpointer,
+	eventChan chan *types.Event) {
+
+	defer close(eventChan)
+
+	var startAtOperationTime *primitive.Timestamp
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		streamOpts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+		if opts.MaxAwaitTime != nil {
+			streamOpts.SetMaxAwaitTime(*opts.MaxAwaitTime)
+		}
+		switch {
+		case opts.StartAfterToken != nil:
+			streamOpts.SetStartAfter(bson.M{"_data": opts.StartAfterToken.Data})
+		case startAtOperationTime != nil:
+			streamOpts.SetStartAtOperationTime(startAtOperationTime)
+		}
+
+		pipeline := mongo.Pipeline{}
+		if opts.OperationType != nil {
+			pipeline = append(pipeline, bson.D{{Key: "$match",
+				Value: bson.M{"operationType": string(*opts.OperationType)}}})
+		}
+		if len(opts.Filter) > 0 {
+			pipeline = append(pipeline, bson.D{{Key: "$match", Value: opts.Filter}})
+		}
+
+		stream, err := col.Watch(ctx, pipeline, streamOpts)
+		if err != nil {
+			blog.Errorf("fail to open change stream for watcher(%s), err: %v", opts.WatcherKey, err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		lost := consumeChangeStream(ctx, stream, opts, cp, eventChan)
+		stream.Close(ctx)
+
+		if !lost {
+			continue
+		}
+
+		blog.Errorf("watcher(%s)'s resume point is too old(ChangeStreamHistoryLost), falling back to "+
+			"the last known cluster time instead of resetting to now", opts.WatcherKey)
+		opts.StartAfterToken = nil
+		startAtOperationTime, _ = lastKnownClusterTime(opts)
+	}
+}
+
+// consumeChangeStream reads stream until ctx is done or stream itself ends, decoding
+// every event onto eventChan and checkpointing it through cp. it returns true only when
+// stream ended because the server rejected it with ChangeStreamHistoryLost.
+func consumeChangeStream(ctx context.Context, stream *mongo.ChangeStream, opts *types.WatchOptions,
+	cp *checkpointer, eventChan chan *types.Event) bool {
+
+	eventStructType := reflect.TypeOf(opts.EventStruct).Elem()
+
+	for stream.Next(ctx) {
+		raw := new(types.EventStream)
+		if err := stream.Decode(raw); err != nil {
+			blog.Errorf("fail to decode change event for watcher(%s), err: %v", opts.WatcherKey, err)
+			continue
+		}
+
+		// allocate a fresh instance per event rather than decoding into the shared
+		// opts.EventStruct: eventChan is buffered, so a burst the consumer can't
+		// keep up with would otherwise leave many buffered Events all pointing at
+		// the one struct that keeps getting overwritten by later events, racing
+		// with whatever the consumer is reading.
+		doc := reflect.New(eventStructType).Interface()
+
+		switch raw.OperationType {
+		case types.Insert, types.Update, types.Replace:
+			if fullDoc, err := stream.Current.LookupErr("fullDocument"); err == nil {
+				if err := bson.Unmarshal(fullDoc.Value, doc); err != nil {
+					blog.Errorf("fail to decode fullDocument for watcher(%s), err: %v", opts.WatcherKey, err)
+				}
+			}
+		}
+
+		eventChan <- &types.Event{
+			Oid:           raw.DocumentKey.ID.Hex(),
+			Document:      doc,
+			OperationType: raw.OperationType,
+		}
+
+		token := raw.Token
+		opts.StartAfterToken = &token
+		cp.Checkpoint(&token, raw.ClusterTime)
+	}
+
+	if err := stream.Err(); err != nil {
+		if cmdErr, ok := err.(mongo.CommandError); ok && cmdErr.Code == changeStreamHistoryLost {
+			return true
+		}
+		blog.Errorf("change stream for watcher(%s) ended with err: %v", opts.WatcherKey, err)
+	}
+	return false
+}
+
+// lastKnownClusterTime asks opts.TokenStore for the cluster time recorded alongside
+// watcherKey's last checkpoint, for the ChangeStreamHistoryLost fallback. it's only
+// available when TokenStore also implements LoadClusterTime, as MongoTokenStore does.
+func lastKnownClusterTime(opts *types.WatchOptions) (*primitive.Timestamp, bool) {
+	store, ok := opts.TokenStore.(interface {
+		LoadClusterTime(watcherKey string) (*primitive.Timestamp, error)
+	})
+	if !ok {
+		return nil, false
+	}
+
+	ts, err := store.LoadClusterTime(opts.WatcherKey)
+	if err != nil || ts == nil {
+		return nil, false
+	}
+	return ts, true
+}